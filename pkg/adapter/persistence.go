@@ -0,0 +1,85 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package adapter
+
+//=============================================================================
+
+//--- State is the serializable snapshot of a ConnectionContext. A durable
+//--- ConnectionStore persists it verbatim (e.g. as a JSON column) and uses
+//--- RestoreConnectionContext to rehydrate the context on startup, without
+//--- the store ever needing to know about adapter-specific internals. Status
+//--- is plain string, the same type GetStatus()/ctx.status already use (see
+//--- MarkErrored's bare ctx.status = "error"), so it round-trips through
+//--- SaveState/RestoreConnectionContext with no conversion either way.
+
+type State struct {
+	Username       string
+	ConnectionCode string
+	InstanceCode   string
+	SystemCode     string
+	ConfigParams   map[string]any
+	ConnectParams  map[string]any
+	RefreshToken   string
+	Status         string
+}
+
+//=============================================================================
+
+//--- SaveState captures everything needed to recreate this context after a
+//--- restart, or on another instance of system-adapter.
+
+func (ctx *ConnectionContext) SaveState() *State {
+	return &State{
+		Username      : ctx.Username,
+		ConnectionCode: ctx.ConnectionCode,
+		InstanceCode  : ctx.InstanceCode,
+		SystemCode    : ctx.GetAdapterInfo().Code,
+		ConfigParams  : ctx.configParams,
+		ConnectParams : ctx.connectParams,
+		RefreshToken  : ctx.refreshToken,
+		Status        : ctx.GetStatus(),
+	}
+}
+
+//=============================================================================
+
+//--- RestoreConnectionContext rebuilds a ConnectionContext from a previously
+//--- saved State. The host (e.g. the request that resolved SystemCode to an
+//--- Adapter) is not known at persistence time, so it is passed in fresh.
+
+func RestoreConnectionContext(state *State, host string, ad Adapter) (*ConnectionContext, error) {
+	ctx, err := NewConnectionContext(state.Username, state.ConnectionCode, host, ad, state.ConfigParams, state.ConnectParams)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.InstanceCode  = state.InstanceCode
+	ctx.refreshToken  = state.RefreshToken
+	ctx.status        = state.Status
+
+	return ctx, nil
+}
+
+//=============================================================================
@@ -0,0 +1,78 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package adapter
+
+import (
+	"errors"
+)
+
+//=============================================================================
+
+//--- Event is a single streaming update (quote, order update, account event,
+//--- ...) delivered by a broker adapter that supports streaming.
+
+type Event struct {
+	Channel string
+	Symbol  string
+	Payload any
+}
+
+//=============================================================================
+
+type CancelFunc func()
+
+//=============================================================================
+
+//--- ErrStreamingNotSupported is returned by ConnectionContext.Subscribe when
+//--- the underlying Adapter does not implement Streamer.
+
+var ErrStreamingNotSupported = errors.New("adapter: this system does not support streaming subscriptions")
+
+//=============================================================================
+
+//--- Streamer is implemented by adapters whose broker exposes streaming
+//--- quotes, order updates or account events. It is kept separate from
+//--- Adapter so that request/response-only adapters are not forced to
+//--- implement it.
+
+type Streamer interface {
+	Subscribe(symbol string, channel string) (<-chan Event, CancelFunc, error)
+}
+
+//=============================================================================
+
+//--- Subscribe opens a streaming subscription on behalf of this connection,
+//--- if the underlying adapter is a Streamer.
+
+func (ctx *ConnectionContext) Subscribe(symbol string, channel string) (<-chan Event, CancelFunc, error) {
+	streamer, ok := ctx.ad.(Streamer)
+	if !ok {
+		return nil, nil, ErrStreamingNotSupported
+	}
+
+	return streamer.Subscribe(symbol, channel)
+}
+
+//=============================================================================
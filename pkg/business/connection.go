@@ -30,33 +30,27 @@ import (
 	"github.com/tradalia/core/msg"
 	"github.com/tradalia/core/req"
 	"github.com/tradalia/system-adapter/pkg/adapter"
-	"sync"
 )
 
-//=============================================================================
-
-var userConnections = struct {
-	sync.RWMutex
-	m map[string]*UserConnections
-}{m: make(map[string]*UserConnections)}
-
 //=============================================================================
 //===
 //=== Public methods
 //===
 //=============================================================================
 
-func GetConnections(c *auth.Context, filter map[string]any, offset int, limit int) *[]*ConnectionInfo {
-	userConnections.RLock()
-	defer userConnections.RUnlock()
+func GetConnections(c *auth.Context, filter map[string]any, offset int, limit int) (*[]*ConnectionInfo, error) {
+	if err := requireScope(c, "", "GetConnections", ScopeConnectionsRead); err != nil {
+		return nil, err
+	}
 
 	us := c.Session
-	uc,found := userConnections.m[us.Username]
+
+	ctxs, err := store.List(us.Username)
 
 	var list []*ConnectionInfo
 
-	if found {
-		for _, ctx := range uc.contexts {
+	if err == nil {
+		for _, ctx := range ctxs {
 			ci := ConnectionInfo{
 				Username      : ctx.Username,
 				ConnectionCode: ctx.ConnectionCode,
@@ -67,23 +61,15 @@ func GetConnections(c *auth.Context, filter map[string]any, offset int, limit in
 		}
 	}
 
-	return &list
+	return &list, nil
 }
 
 //=============================================================================
 
 func GetConnectionsToRefresh() []*adapter.ConnectionContext {
-	userConnections.RLock()
-	defer userConnections.RUnlock()
-
-	var list []*adapter.ConnectionContext
-
-	for _,uc := range userConnections.m {
-		for _, ctx := range uc.contexts {
-			if ctx.NeedsRefresh() {
-				list = append(list, ctx)
-			}
-		}
+	list, err := store.ListForRefresh()
+	if err != nil {
+		return nil
 	}
 
 	return list
@@ -92,38 +78,43 @@ func GetConnectionsToRefresh() []*adapter.ConnectionContext {
 //=============================================================================
 
 func GetConnectionContextByInstanceCode(instanceCode string) *adapter.ConnectionContext {
-	userConnections.RLock()
-	defer userConnections.RUnlock()
-
-	//TODO
-	//for _,uc := range userConnections.m {
-	//	for _,ctx := range uc.contexts {
-	//		if ctx.InstanceCode == instanceCode {
-	//			return ctx
-	//		}
-	//	}
-	//}
+	ctx, found, err := store.GetByInstanceCode(instanceCode)
+	if err != nil || !found {
+		return nil
+	}
 
-	return nil
+	return ctx
 }
 
 //=============================================================================
 
 func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*ConnectionResult, error) {
-	userConnections.Lock()
-	defer userConnections.Unlock()
+	if err := requireScope(c, connectionCode, "Connect", ScopeConnectionsWrite); err != nil {
+		return nil, err
+	}
 
 	user := c.Session.Username
-	uc,found := userConnections.m[user]
 
-	//--- Add entry if it is the first time
+	unlock, err := coordinator.Lock(user, connectionCode)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
+	}
+	defer unlock()
 
-	if !found {
-		uc = NewUserConnections()
-		userConnections.m[user] = uc
+	//--- lockFor guards the context's own fields against RefreshScheduler's
+	//--- worker pool, which can be handed this same pointer by the store the
+	//--- moment it is Put below -- coordinator.Lock above only serializes this
+	//--- Connect/Disconnect critical section, not background refreshes.
+
+	lock := lockFor(user, connectionCode)
+	lock.Lock()
+	defer lock.Unlock()
+
+	ctx, found, err := store.Get(user, connectionCode)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
 	}
 
-	ctx,found := uc.contexts[connectionCode]
 	if found {
 		if ctx.IsConnected() {
 			return &ConnectionResult{
@@ -145,7 +136,6 @@ func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*Conne
 		return nil, req.NewNotFoundError("System not found: %v", cs.SystemCode)
 	}
 
-	var err error
 	ctx,err = adapter.NewConnectionContext(c.Session.Username, connectionCode, c.Gin.Request.Host, ad, cs.ConfigParams, cs.ConnectParams)
 	if err != nil {
 		return &ConnectionResult{
@@ -157,7 +147,10 @@ func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*Conne
 	//--- It is better to store again the context even if it is already there: the user could use the
 	//--- same connection code but with a different adapter
 
-	uc.contexts[connectionCode] = ctx
+	err = store.Put(ctx)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
+	}
 
 	res := &ConnectionResult{
 		Status : ConnectionStatusError,
@@ -170,6 +163,11 @@ func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*Conne
 		return res,nil
 	}
 
+	err = store.Put(ctx)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
+	}
+
 	err = sendConnectionChangeMessage(c, ctx)
 	if err != nil {
 		return &ConnectionResult{
@@ -186,11 +184,10 @@ func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*Conne
 			res.Action  = ConnectionActionOpenUrl
 			res.Message = ctx.GetAdapterAuthUrl()
 
-		//TODO: to review: hardcoded url
 		case adapter.ConnectionResultProxyUrl:
 			res.Status  = ConnectionStatusConnecting
 			res.Action  = ConnectionActionOpenUrl
-			res.Message = "https://tradalia-server:8449/api/system/v1/weblogin/"+ user +"/"+ connectionCode +"/login"
+			res.Message = webhookBaseUrl +"/api/system/v1/weblogin/"+ ctx.InstanceCode +"/login"
 	}
 
 	return res, nil
@@ -199,17 +196,27 @@ func Connect(c *auth.Context, connectionCode string, cs *ConnectionSpec) (*Conne
 //=============================================================================
 
 func Disconnect(c *auth.Context, connectionCode string) error {
+	if err := requireScope(c, connectionCode, "Disconnect", ScopeConnectionsWrite); err != nil {
+		return err
+	}
+
 	user := c.Session.Username
 
-	userConnections.Lock()
-	defer userConnections.Unlock()
+	unlock, err := coordinator.Lock(user, connectionCode)
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
+	defer unlock()
+
+	lock := lockFor(user, connectionCode)
+	lock.Lock()
+	defer lock.Unlock()
 
-	uc, ok := userConnections.m[user]
-	if !ok {
-		return req.NewNotFoundError("Connection not found for user: %v", user)
+	ctx, found, err := store.Get(user, connectionCode)
+	if err != nil {
+		return req.NewServerErrorByError(err)
 	}
 
-	ctx, found := uc.contexts[connectionCode]
 	if !found {
 		return req.NewNotFoundError("Connection not found: %v", connectionCode)
 	}
@@ -218,13 +225,22 @@ func Disconnect(c *auth.Context, connectionCode string) error {
 		return nil
 	}
 
-	err := sendConnectionChangeMessage(c, ctx)
+	//--- Disconnect first, same as RefreshScheduler.recordFailure does with
+	//--- MarkErrored: sendConnectionChangeMessage's publishConnectionChange
+	//--- gates DropConnection on !ctx.IsConnected(), so subscribers only get
+	//--- torn down if that check runs against the post-disconnect status.
+
+	_ = ctx.Disconnect()
+
+	err = sendConnectionChangeMessage(c, ctx)
 	if err != nil {
 		return req.NewServerErrorByError(err)
 	}
 
-	delete(uc.contexts, connectionCode)
-	_ = ctx.Disconnect()
+	err = store.Delete(user, connectionCode)
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
 
 	return nil
 }
@@ -236,88 +252,105 @@ func Disconnect(c *auth.Context, connectionCode string) error {
 //=============================================================================
 
 func GetRootSymbols(c *auth.Context, connectionCode string, filter string) ([]*adapter.RootSymbol, error){
-	ctx,err := getConnectionContext(c, connectionCode)
-	if err != nil {
+	if err := requireScope(c, connectionCode, "GetRootSymbols", ScopeMarketDataRead); err != nil {
 		return nil,err
 	}
 
-	return ctx.GetRootSymbols(filter)
+	return withConnectionRLock(c, connectionCode, func(ctx *adapter.ConnectionContext) ([]*adapter.RootSymbol, error) {
+		return ctx.GetRootSymbols(filter)
+	})
 }
 
 //=============================================================================
 
 func GetRootSymbol(c *auth.Context, connectionCode string, root string) (*adapter.RootSymbol, error){
-	ctx,err := getConnectionContext(c, connectionCode)
-	if err != nil {
+	if err := requireScope(c, connectionCode, "GetRootSymbol", ScopeMarketDataRead); err != nil {
 		return nil,err
 	}
 
-	return ctx.GetRootSymbol(root)
+	return withConnectionRLock(c, connectionCode, func(ctx *adapter.ConnectionContext) (*adapter.RootSymbol, error) {
+		return ctx.GetRootSymbol(root)
+	})
 }
 
 //=============================================================================
 
 func GetInstruments(c *auth.Context, connectionCode string, root string) ([]*adapter.Instrument, error){
-	ctx,err := getConnectionContext(c, connectionCode)
-	if err != nil {
+	if err := requireScope(c, connectionCode, "GetInstruments", ScopeMarketDataRead); err != nil {
 		return nil,err
 	}
 
-	return ctx.GetInstruments(root)
+	return withConnectionRLock(c, connectionCode, func(ctx *adapter.ConnectionContext) ([]*adapter.Instrument, error) {
+		return ctx.GetInstruments(root)
+	})
 }
 
 //=============================================================================
 
 func GetPriceBars(c *auth.Context, connectionCode string, symbol string, date datatype.IntDate) (*adapter.PriceBars, error){
-	ctx,err := getConnectionContext(c, connectionCode)
-	if err != nil {
+	if err := requireScope(c, connectionCode, "GetPriceBars", ScopeMarketDataRead); err != nil {
 		return nil,err
 	}
 
-	return ctx.GetPriceBars(symbol, date)
+	return withConnectionRLock(c, connectionCode, func(ctx *adapter.ConnectionContext) (*adapter.PriceBars, error) {
+		return ctx.GetPriceBars(symbol, date)
+	})
 }
 
 //=============================================================================
 
 func GetAccounts(c *auth.Context, connectionCode string) ([]*adapter.Account, error){
-	ctx,err := getConnectionContext(c, connectionCode)
-	if err != nil {
+	if err := requireScope(c, connectionCode, "GetAccounts", ScopeAccountsRead); err != nil {
 		return nil,err
 	}
 
-	return ctx.GetAccounts()
+	return withConnectionRLock(c, connectionCode, func(ctx *adapter.ConnectionContext) ([]*adapter.Account, error) {
+		return ctx.GetAccounts()
+	})
 }
 
 //=============================================================================
 
 func GetOrders(c *auth.Context, connectionCode string) (any, error){
+	if err := requireScope(c, connectionCode, "GetOrders", ScopeAccountsRead); err != nil {
+		return nil,err
+	}
+
 	return nil,nil
 }
 
 //=============================================================================
 
 func GetPositions(c *auth.Context, connectionCode string) (any, error){
+	if err := requireScope(c, connectionCode, "GetPositions", ScopeAccountsRead); err != nil {
+		return nil,err
+	}
+
 	return nil,nil
 }
 
 //=============================================================================
 
 func TestAdapter(c *auth.Context, connectionCode string, tar *TestAdapterRequest) (string, error){
-	userConnections.RLock()
+	if err := requireScope(c, connectionCode, "TestAdapter", ScopeAdminTest); err != nil {
+		return "",err
+	}
 
 	user := c.Session.Username
-	uc, ok := userConnections.m[user]
-	if !ok {
-		userConnections.RUnlock()
-		return "",req.NewNotFoundError("Connection not found for user: %v", user)
+
+	ctx, found, err := store.Get(user, connectionCode)
+	if err != nil {
+		return "", req.NewServerErrorByError(err)
 	}
 
-	ctx, found := uc.contexts[connectionCode]
-	userConnections.RUnlock()
 	if !found {
 		return "",req.NewNotFoundError("Connection not found: %v", connectionCode)
 	}
 
+	lock := lockFor(ctx.Username, ctx.ConnectionCode)
+	lock.RLock()
+	defer lock.RUnlock()
+
 	return ctx.TestAdapter(tar.Service, tar.Query)
 }
 
@@ -328,36 +361,51 @@ func TestAdapter(c *auth.Context, connectionCode string, tar *TestAdapterRequest
 //=============================================================================
 
 func sendConnectionChangeMessage(c *auth.Context, ctx *adapter.ConnectionContext) error {
+	err := publishConnectionChange(ctx)
+	if err != nil {
+		c.Log.Error("sendConnectionChangeMessage: Could not publish the change message", "error", err.Error())
+		return err
+	}
+
+	return nil
+}
+
+//=============================================================================
+
+//--- publishConnectionChange is the logging-free core of
+//--- sendConnectionChangeMessage, shared with callers (like RefreshScheduler)
+//--- that have no *auth.Context to log against.
+
+func publishConnectionChange(ctx *adapter.ConnectionContext) error {
+	if !ctx.IsConnected() {
+		subscriptions.DropConnection(ctx.ConnectionCode)
+	}
+
 	ccm := ConnectionChangeSystemMessage{
 		Username      : ctx.Username,
 		ConnectionCode: ctx.ConnectionCode,
 		SystemCode    : ctx.GetAdapterInfo().Code,
 		Status        : ctx.GetStatus(),
 	}
-	err := msg.SendMessage(msg.ExSystem, msg.SourceConnection, msg.TypeChange, &ccm)
 
+	err := msg.SendMessage(msg.ExSystem, msg.SourceConnection, msg.TypeChange, &ccm)
 	if err != nil {
-		c.Log.Error("sendConnectionChangeMessage: Could not publish the change message", "error", err.Error())
 		return err
 	}
 
-	return nil
+	return coordinator.PublishChange(ctx)
 }
 
 //=============================================================================
 
 func getConnectionContext(c *auth.Context, connectionCode string) (*adapter.ConnectionContext, error) {
-	userConnections.RLock()
-
 	user := c.Session.OnBehalfOf
-	uc, ok := userConnections.m[user]
-	if !ok {
-		userConnections.RUnlock()
-		return nil,req.NewNotFoundError("Connection not found for user: %v", user)
+
+	ctx, found, err := store.Get(user, connectionCode)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
 	}
 
-	ctx, found := uc.contexts[connectionCode]
-	userConnections.RUnlock()
 	if !found {
 		return nil,req.NewNotFoundError("Connection not found: %v", connectionCode)
 	}
@@ -0,0 +1,77 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"sync"
+
+	"github.com/tradalia/core/auth"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- contextLocks guards the fields of a single *adapter.ConnectionContext
+//--- against concurrent access between RefreshScheduler's background worker
+//--- pool and the request-handling goroutines (Connect/Disconnect and the
+//--- read-only services) that can be handed the very same pointer by
+//--- MemoryConnectionStore. coordinator.Lock is a different, coarser lock:
+//--- it serializes the check-then-act Connect/Disconnect critical section
+//--- (and across replicas, when clustered); this one only ever protects the
+//--- context's own fields, for as long as a single call touches them.
+
+var contextLocks sync.Map // key: username+"/"+connectionCode -> *sync.RWMutex
+
+//=============================================================================
+
+func lockFor(username string, connectionCode string) *sync.RWMutex {
+	key := username + "/" + connectionCode
+
+	actual, _ := contextLocks.LoadOrStore(key, &sync.RWMutex{})
+	return actual.(*sync.RWMutex)
+}
+
+//=============================================================================
+
+//--- withConnectionRLock resolves connectionCode to its ConnectionContext and
+//--- runs fn against it under a read lock, so a concurrent RefreshScheduler
+//--- refresh can't mutate the context's fields while fn is reading them.
+
+func withConnectionRLock[T any](c *auth.Context, connectionCode string, fn func(ctx *adapter.ConnectionContext) (T, error)) (T, error) {
+	var zero T
+
+	ctx, err := getConnectionContext(c, connectionCode)
+	if err != nil {
+		return zero, err
+	}
+
+	lock := lockFor(ctx.Username, ctx.ConnectionCode)
+	lock.RLock()
+	defer lock.RUnlock()
+
+	return fn(ctx)
+}
+
+//=============================================================================
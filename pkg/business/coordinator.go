@@ -0,0 +1,145 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"sync"
+
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- ConnectionCoordinator lets several system-adapter replicas share a
+//--- single logical connection. Lock/Unlock serialize the critical sections
+//--- in Connect/Disconnect across the cluster, PublishChange fans out a
+//--- ConnectionChangeSystemMessage to every replica and Subscribe is how a
+//--- replica learns that another node changed or dropped a connection it
+//--- has cached locally.
+
+type ConnectionCoordinator interface {
+	Lock(username string, connectionCode string) (unlock func(), err error)
+	PublishChange(ctx *adapter.ConnectionContext) error
+	Subscribe(onChange func(username string, connectionCode string)) error
+}
+
+//=============================================================================
+
+//--- localCoordinator is the default, single-process ConnectionCoordinator.
+//--- There is nobody else to publish to, but Lock still has real work to do:
+//--- the store's RWMutex only serializes individual Get/Put calls, not the
+//--- compound check-then-act critical sections in Connect/Disconnect, so
+//--- locking is backed by a real per-(username, connectionCode) mutex.
+
+type localCoordinator struct {
+	mu    sync.Mutex
+	locks map[string]*sync.Mutex
+}
+
+//=============================================================================
+
+func newLocalCoordinator() *localCoordinator {
+	return &localCoordinator{locks: make(map[string]*sync.Mutex)}
+}
+
+//=============================================================================
+
+func (lc *localCoordinator) Lock(username string, connectionCode string) (func(), error) {
+	key := username + "/" + connectionCode
+
+	lc.mu.Lock()
+	m, found := lc.locks[key]
+	if !found {
+		m = &sync.Mutex{}
+		lc.locks[key] = m
+	}
+	lc.mu.Unlock()
+
+	m.Lock()
+	return m.Unlock, nil
+}
+
+//=============================================================================
+
+func (*localCoordinator) PublishChange(*adapter.ConnectionContext) error {
+	return nil
+}
+
+//=============================================================================
+
+func (*localCoordinator) Subscribe(func(string, string)) error {
+	return nil
+}
+
+//=============================================================================
+
+var coordinator ConnectionCoordinator = newLocalCoordinator()
+
+//=============================================================================
+
+//--- ClusterEnabled reports whether a distributed ConnectionCoordinator is
+//--- in use, i.e. whether this process was started with --cluster.
+
+var ClusterEnabled = false
+
+//=============================================================================
+
+//--- SetConnectionCoordinator wires a distributed ConnectionCoordinator
+//--- (e.g. NewRedisConnectionCoordinator) and marks the cluster as enabled.
+//--- It must be called during startup, before the first connection is
+//--- established, same as SetConnectionStore.
+
+func SetConnectionCoordinator(cc ConnectionCoordinator) {
+	coordinator = cc
+	ClusterEnabled = true
+}
+
+//=============================================================================
+
+//--- CacheInvalidator is implemented by a ConnectionStore that keeps a local,
+//--- in-process copy of contexts owned by the durable backend (e.g. a
+//--- caching decorator in front of SqlConnectionStore). StartClusterSync uses
+//--- it to drop stale entries when another replica changes a connection.
+
+type CacheInvalidator interface {
+	Invalidate(username string, connectionCode string)
+}
+
+//=============================================================================
+
+//--- StartClusterSync subscribes to the coordinator and, if the active store
+//--- is a CacheInvalidator, drops its local copy of whatever connection just
+//--- changed on another replica. It blocks, so call it from its own
+//--- goroutine once SetConnectionCoordinator has run.
+
+func StartClusterSync() error {
+	return coordinator.Subscribe(func(username string, connectionCode string) {
+		if ci, ok := store.(CacheInvalidator); ok {
+			ci.Invalidate(username, connectionCode)
+		}
+	})
+}
+
+//=============================================================================
@@ -0,0 +1,191 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+const (
+	lockTtl       = 10 * time.Second
+	lockRenewTtl  = lockTtl / 2
+	changeChannel = "system-adapter:connection-change"
+)
+
+//=============================================================================
+
+//--- unlockScript releases the lock only if it still holds the token this
+//--- node set, so a node whose critical section outran lockTtl never deletes
+//--- a lock another node has since acquired.
+
+var unlockScript = redis.NewScript(`
+	if redis.call("get", KEYS[1]) == ARGV[1] then
+		return redis.call("del", KEYS[1])
+	end
+	return 0
+`)
+
+//=============================================================================
+
+//--- renewScript extends the lock's TTL only if it still holds the token
+//--- this node set, for the same reason unlockScript checks it.
+
+var renewScript = redis.NewScript(`
+	if redis.call("get", KEYS[1]) == ARGV[1] then
+		return redis.call("pexpire", KEYS[1], ARGV[2])
+	end
+	return 0
+`)
+
+//=============================================================================
+
+//--- connectionChangeEvent is what gets published on changeChannel so that
+//--- every replica can invalidate or refresh whatever it has cached locally
+//--- for (Username, ConnectionCode).
+
+type connectionChangeEvent struct {
+	Username      string `json:"username"`
+	ConnectionCode string `json:"connectionCode"`
+}
+
+//=============================================================================
+
+//--- RedisConnectionCoordinator is a ConnectionCoordinator backed by Redis:
+//--- SET NX PX for the distributed lock and Pub/Sub for change notification.
+
+type RedisConnectionCoordinator struct {
+	client *redis.Client
+}
+
+//=============================================================================
+
+func NewRedisConnectionCoordinator(client *redis.Client) *RedisConnectionCoordinator {
+	return &RedisConnectionCoordinator{client: client}
+}
+
+//=============================================================================
+
+func (cc *RedisConnectionCoordinator) Lock(username string, connectionCode string) (func(), error) {
+	ctx := context.Background()
+	key := lockKey(username, connectionCode)
+
+	token := fmt.Sprintf("%s-%d", connectionCode, time.Now().UnixNano())
+
+	ok, err := cc.client.SetNX(ctx, key, token, lockTtl).Result()
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		return nil, fmt.Errorf("connection %s/%s is locked by another node", username, connectionCode)
+	}
+
+	stopRenewal := make(chan struct{})
+	go cc.renewLock(key, token, stopRenewal)
+
+	unlock := func() {
+		close(stopRenewal)
+		unlockScript.Run(context.Background(), cc.client, []string{key}, token)
+	}
+
+	return unlock, nil
+}
+
+//=============================================================================
+
+//--- renewLock keeps a long-running critical section's lock alive past
+//--- lockTtl by extending it at half that interval, until unlock stops it.
+//--- It only ever extends a lock still holding our own token, so a renewal
+//--- racing the lock's natural expiry can't resurrect it for us once another
+//--- node has acquired it.
+
+func (cc *RedisConnectionCoordinator) renewLock(key string, token string, stop <-chan struct{}) {
+	ticker := time.NewTicker(lockRenewTtl)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <-stop:
+				return
+
+			case <-ticker.C:
+				renewScript.Run(context.Background(), cc.client, []string{key}, token, lockTtl.Milliseconds())
+		}
+	}
+}
+
+//=============================================================================
+
+func (cc *RedisConnectionCoordinator) PublishChange(ctx *adapter.ConnectionContext) error {
+	data, err := json.Marshal(connectionChangeEvent{
+		Username      : ctx.Username,
+		ConnectionCode: ctx.ConnectionCode,
+	})
+	if err != nil {
+		return err
+	}
+
+	return cc.client.Publish(context.Background(), changeChannel, data).Err()
+}
+
+//=============================================================================
+
+//--- Subscribe blocks, dispatching onChange for every message received on
+//--- changeChannel, until the subscription fails. Call it from its own
+//--- goroutine during startup.
+
+func (cc *RedisConnectionCoordinator) Subscribe(onChange func(username string, connectionCode string)) error {
+	sub := cc.client.Subscribe(context.Background(), changeChannel)
+	defer sub.Close()
+
+	for msg := range sub.Channel() {
+		var event connectionChangeEvent
+
+		err := json.Unmarshal([]byte(msg.Payload), &event)
+		if err != nil {
+			continue
+		}
+
+		onChange(event.Username, event.ConnectionCode)
+	}
+
+	return nil
+}
+
+//=============================================================================
+
+func lockKey(username string, connectionCode string) string {
+	return "system-adapter:lock:" + username + ":" + connectionCode
+}
+
+//=============================================================================
@@ -0,0 +1,123 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+//=============================================================================
+
+func TestLocalCoordinatorLockExcludesSameKey(t *testing.T) {
+	lc := newLocalCoordinator()
+
+	unlock, err := lc.Lock("alice", "conn1")
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+
+	acquired := make(chan struct{})
+
+	go func() {
+		second, err := lc.Lock("alice", "conn1")
+		if err != nil {
+			t.Errorf("second Lock failed: %v", err)
+			return
+		}
+		second()
+		close(acquired)
+	}()
+
+	select {
+		case <-acquired:
+			t.Fatal("expected the second Lock on the same key to block while the first is held")
+		case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+
+	select {
+		case <-acquired:
+		case <-time.After(time.Second):
+			t.Fatal("expected the second Lock to acquire once the first was released")
+	}
+}
+
+//=============================================================================
+
+func TestLocalCoordinatorLockAllowsDifferentKeys(t *testing.T) {
+	lc := newLocalCoordinator()
+
+	unlockA, err := lc.Lock("alice", "conn1")
+	if err != nil {
+		t.Fatalf("Lock failed: %v", err)
+	}
+	defer unlockA()
+
+	done := make(chan struct{})
+
+	go func() {
+		unlockB, err := lc.Lock("alice", "conn2")
+		if err != nil {
+			t.Errorf("Lock on a different key failed: %v", err)
+			return
+		}
+		unlockB()
+		close(done)
+	}()
+
+	select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatal("expected Lock on a different key to proceed without waiting")
+	}
+}
+
+//=============================================================================
+
+func TestLocalCoordinatorLockConcurrentDifferentKeysNoRace(t *testing.T) {
+	lc := newLocalCoordinator()
+
+	var wg sync.WaitGroup
+
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(n int) {
+			defer wg.Done()
+			unlock, err := lc.Lock("alice", string(rune('a'+n)))
+			if err != nil {
+				t.Errorf("Lock failed: %v", err)
+				return
+			}
+			unlock()
+		}(i)
+	}
+
+	wg.Wait()
+}
+
+//=============================================================================
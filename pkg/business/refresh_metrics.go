@@ -0,0 +1,60 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+//=============================================================================
+
+var refreshSuccessTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "refresh_success_total",
+	Help: "Number of connection token refreshes that succeeded",
+})
+
+//=============================================================================
+
+var refreshFailureTotal = promauto.NewCounter(prometheus.CounterOpts{
+	Name: "refresh_failure_total",
+	Help: "Number of connection token refreshes that failed",
+})
+
+//=============================================================================
+
+var refreshDurationSeconds = promauto.NewHistogram(prometheus.HistogramOpts{
+	Name: "refresh_duration_seconds",
+	Help: "Time taken to refresh a single connection token",
+})
+
+//=============================================================================
+
+var connectionsByStatus = promauto.NewGaugeVec(prometheus.GaugeOpts{
+	Name: "connections_by_status",
+	Help: "Number of connections currently in each status",
+}, []string{"status"})
+
+//=============================================================================
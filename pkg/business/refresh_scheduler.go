@@ -0,0 +1,289 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+const (
+	defaultRefreshInterval = 30 * time.Second
+	defaultRefreshWorkers  = 8
+	maxConsecutiveFailures = 5
+	baseBackoff            = 5 * time.Second
+	maxBackoff              = 10 * time.Minute
+)
+
+//=============================================================================
+
+//--- refreshState tracks the backoff for a single (user, connectionCode)
+//--- across scheduler ticks.
+
+type refreshState struct {
+	failures    int
+	nextAttempt time.Time
+}
+
+//=============================================================================
+
+//--- RefreshScheduler periodically walks the ConnectionStore for contexts
+//--- that NeedsRefresh(), refreshes them concurrently with a bounded worker
+//--- pool, and backs off (with jitter) on failure. A connection is marked
+//--- errored after maxConsecutiveFailures in a row.
+
+type RefreshScheduler struct {
+	interval time.Duration
+	workers  int
+
+	mu     sync.Mutex
+	states map[string]*refreshState
+
+	stopCh chan struct{}
+	wg     sync.WaitGroup
+}
+
+//=============================================================================
+
+func NewRefreshScheduler() *RefreshScheduler {
+	return &RefreshScheduler{
+		interval: defaultRefreshInterval,
+		workers : defaultRefreshWorkers,
+		states  : make(map[string]*refreshState),
+	}
+}
+
+//=============================================================================
+
+//--- Start launches the scheduler's background goroutine. Stop must be
+//--- called to release it, typically during graceful shutdown.
+
+func (s *RefreshScheduler) Start() {
+	s.stopCh = make(chan struct{})
+
+	s.wg.Add(1)
+	go s.run()
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) Stop() {
+	close(s.stopCh)
+	s.wg.Wait()
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) run() {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(s.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case <-s.stopCh:
+				return
+
+			case <-ticker.C:
+				s.tick()
+		}
+	}
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) tick() {
+	ctxs := GetConnectionsToRefresh()
+
+	sem := make(chan struct{}, s.workers)
+	var wg sync.WaitGroup
+
+	now := time.Now()
+
+	for _, ctx := range ctxs {
+		if s.nextAttempt(ctx).After(now) {
+			continue
+		}
+
+		wg.Add(1)
+		sem <- struct{}{}
+
+		go func(ctx *adapter.ConnectionContext) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			s.refresh(ctx)
+		}(ctx)
+	}
+
+	wg.Wait()
+
+	s.updateConnectionMetrics()
+}
+
+//=============================================================================
+
+//--- updateConnectionMetrics reports connectionsByStatus over the full
+//--- connection population, not just the subset due for a token refresh, so
+//--- a rising error count shows up even when every such connection has
+//--- already exhausted its backoff window.
+
+func (s *RefreshScheduler) updateConnectionMetrics() {
+	all, err := store.ListAll()
+	if err != nil {
+		return
+	}
+
+	counts := map[string]int{}
+
+	for _, ctx := range all {
+		lock := lockFor(ctx.Username, ctx.ConnectionCode)
+		lock.RLock()
+		status := ctx.GetStatus()
+		lock.RUnlock()
+
+		counts[status]++
+	}
+
+	connectionsByStatus.Reset()
+
+	for status, n := range counts {
+		connectionsByStatus.WithLabelValues(status).Set(float64(n))
+	}
+}
+
+//=============================================================================
+
+//--- refresh mutates ctx (Refresh/MarkErrored), so it takes the same
+//--- per-context write lock Connect/Disconnect use, to stay safe against a
+//--- concurrent request handler reading this same pointer via
+//--- withConnectionRLock/store.Get. It also takes coordinator.Lock, same as
+//--- Connect/Disconnect: in --cluster mode every replica runs its own
+//--- RefreshScheduler against the same shared store, and for brokers with
+//--- single-use/rotating refresh tokens two nodes refreshing the same
+//--- connection at the same tick would fail one of them every time.
+
+func (s *RefreshScheduler) refresh(ctx *adapter.ConnectionContext) {
+	key := ctx.Username + "/" + ctx.ConnectionCode
+
+	unlock, err := coordinator.Lock(ctx.Username, ctx.ConnectionCode)
+	if err != nil {
+		return
+	}
+	defer unlock()
+
+	lock := lockFor(ctx.Username, ctx.ConnectionCode)
+	lock.Lock()
+	start := time.Now()
+	err = ctx.Refresh()
+	refreshDurationSeconds.Observe(time.Since(start).Seconds())
+	lock.Unlock()
+
+	if err != nil {
+		refreshFailureTotal.Inc()
+		s.recordFailure(key, ctx)
+		return
+	}
+
+	refreshSuccessTotal.Inc()
+	s.clearFailures(key)
+
+	_ = store.Put(ctx)
+	_ = publishConnectionChange(ctx)
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) recordFailure(key string, ctx *adapter.ConnectionContext) {
+	s.mu.Lock()
+	st, found := s.states[key]
+	if !found {
+		st = &refreshState{}
+		s.states[key] = st
+	}
+	st.failures++
+	st.nextAttempt = time.Now().Add(backoffWithJitter(st.failures))
+	failures := st.failures
+	s.mu.Unlock()
+
+	if failures >= maxConsecutiveFailures {
+		lock := lockFor(ctx.Username, ctx.ConnectionCode)
+		lock.Lock()
+		ctx.MarkErrored("too many consecutive refresh failures")
+		lock.Unlock()
+
+		_ = store.Put(ctx)
+		_ = publishConnectionChange(ctx)
+	}
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) clearFailures(key string) {
+	s.mu.Lock()
+	delete(s.states, key)
+	s.mu.Unlock()
+}
+
+//=============================================================================
+
+func (s *RefreshScheduler) nextAttempt(ctx *adapter.ConnectionContext) time.Time {
+	key := ctx.Username + "/" + ctx.ConnectionCode
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	st, found := s.states[key]
+	if !found {
+		return time.Time{}
+	}
+
+	return st.nextAttempt
+}
+
+//=============================================================================
+
+//--- backoffWithJitter grows exponentially with the number of consecutive
+//--- failures, capped at maxBackoff, with up to 20% random jitter so that a
+//--- burst of failures doesn't retry in lockstep.
+
+func backoffWithJitter(failures int) time.Duration {
+	backoff := baseBackoff * time.Duration(1<<uint(failures-1))
+	if backoff > maxBackoff {
+		backoff = maxBackoff
+	}
+
+	jitter := time.Duration(rand.Int63n(int64(backoff) / 5))
+
+	return backoff + jitter
+}
+
+//=============================================================================
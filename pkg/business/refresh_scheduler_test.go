@@ -0,0 +1,61 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"testing"
+	"time"
+)
+
+//=============================================================================
+
+func TestBackoffWithJitterGrowsExponentially(t *testing.T) {
+	first := backoffWithJitter(1)
+	second := backoffWithJitter(2)
+
+	if first < baseBackoff {
+		t.Fatalf("expected backoffWithJitter(1) to be at least baseBackoff, got %v", first)
+	}
+
+	if second < 2*first-2*baseBackoff/5 {
+		t.Fatalf("expected backoffWithJitter(2) to roughly double backoffWithJitter(1), got %v vs %v", second, first)
+	}
+}
+
+//=============================================================================
+
+func TestBackoffWithJitterCapsAtMaxBackoff(t *testing.T) {
+	backoff := backoffWithJitter(20)
+
+	if backoff < maxBackoff {
+		t.Fatalf("expected backoffWithJitter to be at least maxBackoff once capped, got %v", backoff)
+	}
+
+	if backoff > maxBackoff+maxBackoff/5+time.Second {
+		t.Fatalf("expected backoffWithJitter's jitter to stay within ~20%% of maxBackoff, got %v", backoff)
+	}
+}
+
+//=============================================================================
@@ -0,0 +1,149 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"github.com/tradalia/core/auth"
+	"github.com/tradalia/core/req"
+)
+
+//=============================================================================
+
+//--- Scope is a single permission an API token or session can be granted.
+//--- Every business function that touches a connection checks for one of
+//--- these with requireScope before doing anything else.
+
+type Scope string
+
+const (
+	ScopeMarketDataRead   Scope = "market_data:read"
+	ScopeAccountsRead     Scope = "accounts:read"
+	ScopeOrdersWrite      Scope = "orders:write"
+	ScopeAdminTest        Scope = "admin:test"
+	ScopeConnectionsRead  Scope = "connections:read"
+	ScopeConnectionsWrite Scope = "connections:write"
+
+	//--- Required in addition to the scope above whenever Session.OnBehalfOf
+	//--- differs from Session.Username, i.e. an admin acting for someone else.
+
+	ScopeImpersonate Scope = "impersonate"
+)
+
+//=============================================================================
+
+//--- ScopesForSession resolves the scopes granted to the caller behind c, by
+//--- reading the scopes carried by the session/API token the auth package
+//--- already attaches to c.Session. A session with no Scopes grants nothing:
+//--- the policy fails closed, so a token minted before scopes existed (or one
+//--- that simply forgot to ask for a scope) is denied rather than trusted.
+//--- Tests (and deployments that need a different source of truth) can
+//--- override this with SetScopeProvider.
+
+var ScopesForSession = func(c *auth.Context) []Scope {
+	scopes := make([]Scope, 0, len(c.Session.Scopes))
+
+	for _, s := range c.Session.Scopes {
+		scopes = append(scopes, Scope(s))
+	}
+
+	return scopes
+}
+
+//=============================================================================
+
+func SetScopeProvider(f func(c *auth.Context) []Scope) {
+	ScopesForSession = f
+}
+
+//=============================================================================
+
+func hasScope(c *auth.Context, scope Scope) bool {
+	for _, s := range ScopesForSession(c) {
+		if s == scope {
+			return true
+		}
+	}
+
+	return false
+}
+
+//=============================================================================
+
+//--- evaluateScope is the pure policy decision behind requireScope, kept
+//--- separate so it can be unit tested without a *auth.Context wired to a
+//--- real logger. It enforces the impersonate policy: acting on behalf of
+//--- someone else requires ScopeImpersonate on top of the service-specific
+//--- scope.
+
+func evaluateScope(c *auth.Context, scope Scope) (allowed bool, reason string) {
+	us := c.Session
+
+	if us.OnBehalfOf != us.Username && !hasScope(c, ScopeImpersonate) {
+		return false, "denied: impersonation requires the impersonate scope"
+	}
+
+	if !hasScope(c, scope) {
+		return false, "denied: missing scope"
+	}
+
+	return true, "allowed"
+}
+
+//=============================================================================
+
+//--- requireScope is the guard every business function invokes before
+//--- touching a connection. It always records the decision through
+//--- auditServiceCall, whatever the outcome.
+
+func requireScope(c *auth.Context, connectionCode string, service string, scope Scope) error {
+	allowed, reason := evaluateScope(c, scope)
+
+	auditServiceCall(c, connectionCode, service, scope, reason)
+
+	if !allowed {
+		return req.NewForbiddenError("%v", reason)
+	}
+
+	return nil
+}
+
+//=============================================================================
+
+//--- auditServiceCall records (user, on_behalf_of, connection_code, service,
+//--- scope, result) for every call guarded by requireScope, allowed or not.
+
+func auditServiceCall(c *auth.Context, connectionCode string, service string, scope Scope, result string) {
+	us := c.Session
+
+	c.Log.Info("audit: system-adapter service call",
+		"user"          , us.Username,
+		"onBehalfOf"    , us.OnBehalfOf,
+		"connectionCode", connectionCode,
+		"service"       , service,
+		"scope"         , scope,
+		"result"        , result)
+}
+
+//=============================================================================
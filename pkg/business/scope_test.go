@@ -0,0 +1,95 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"testing"
+
+	"github.com/tradalia/core/auth"
+)
+
+//=============================================================================
+
+func sessionContext(username string, onBehalfOf string, scopes ...string) *auth.Context {
+	return &auth.Context{
+		Session: auth.Session{
+			Username  : username,
+			OnBehalfOf: onBehalfOf,
+			Scopes    : scopes,
+		},
+	}
+}
+
+//=============================================================================
+
+func TestScopesForSessionFailsClosedWithNoScopes(t *testing.T) {
+	c := sessionContext("alice", "alice")
+
+	if hasScope(c, ScopeMarketDataRead) {
+		t.Fatal("a session with no granted scopes must not pass hasScope")
+	}
+}
+
+//=============================================================================
+
+func TestEvaluateScopeDeniesMissingScope(t *testing.T) {
+	c := sessionContext("alice", "alice", string(ScopeAccountsRead))
+
+	if allowed, _ := evaluateScope(c, ScopeMarketDataRead); allowed {
+		t.Fatal("expected evaluateScope to deny a caller without the required scope")
+	}
+}
+
+//=============================================================================
+
+func TestEvaluateScopeAllowsGrantedScope(t *testing.T) {
+	c := sessionContext("alice", "alice", string(ScopeMarketDataRead))
+
+	if allowed, reason := evaluateScope(c, ScopeMarketDataRead); !allowed {
+		t.Fatalf("expected evaluateScope to allow a caller with the required scope, got: %v", reason)
+	}
+}
+
+//=============================================================================
+
+func TestEvaluateScopeDeniesImpersonationWithoutImpersonateScope(t *testing.T) {
+	c := sessionContext("admin", "alice", string(ScopeMarketDataRead))
+
+	if allowed, _ := evaluateScope(c, ScopeMarketDataRead); allowed {
+		t.Fatal("expected evaluateScope to deny OnBehalfOf != Username without ScopeImpersonate")
+	}
+}
+
+//=============================================================================
+
+func TestEvaluateScopeAllowsImpersonationWithImpersonateScope(t *testing.T) {
+	c := sessionContext("admin", "alice", string(ScopeMarketDataRead), string(ScopeImpersonate))
+
+	if allowed, reason := evaluateScope(c, ScopeMarketDataRead); !allowed {
+		t.Fatalf("expected evaluateScope to allow impersonation granted ScopeImpersonate, got: %v", reason)
+	}
+}
+
+//=============================================================================
@@ -0,0 +1,64 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- ConnectionStore is the persistence boundary for connection contexts. The
+//--- default implementation keeps everything in memory (fine for a single
+//--- process and for tests) but a durable backend can be plugged in with
+//--- SetConnectionStore so that refresh tokens, ConfigParams/ConnectParams
+//--- and instance codes survive a restart and can be shared across replicas.
+
+type ConnectionStore interface {
+	Get(username string, connectionCode string) (*adapter.ConnectionContext, bool, error)
+	GetByInstanceCode(instanceCode string) (*adapter.ConnectionContext, bool, error)
+	Put(ctx *adapter.ConnectionContext) error
+	Delete(username string, connectionCode string) error
+	List(username string) ([]*adapter.ConnectionContext, error)
+	ListForRefresh() ([]*adapter.ConnectionContext, error)
+	ListAll() ([]*adapter.ConnectionContext, error)
+}
+
+//=============================================================================
+
+var store ConnectionStore = NewMemoryConnectionStore()
+
+//=============================================================================
+
+//--- SetConnectionStore replaces the active ConnectionStore. It must be called
+//--- before the first connection is established (typically during startup,
+//--- right after the config has been read) since it is not safe to swap the
+//--- store while requests are in flight.
+
+func SetConnectionStore(s ConnectionStore) {
+	store = s
+}
+
+//=============================================================================
@@ -0,0 +1,195 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"sync"
+
+	"github.com/tradalia/core/req"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- MemoryConnectionStore is the process-local ConnectionStore used when no
+//--- durable backend is configured, and by the test suite. Nothing here
+//--- survives a restart and nothing is shared across replicas.
+
+type MemoryConnectionStore struct {
+	sync.RWMutex
+	byUser     map[string]*UserConnections
+	byInstance map[string]*adapter.ConnectionContext
+}
+
+//=============================================================================
+
+func NewMemoryConnectionStore() *MemoryConnectionStore {
+	return &MemoryConnectionStore{
+		byUser    : make(map[string]*UserConnections),
+		byInstance: make(map[string]*adapter.ConnectionContext),
+	}
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) Get(username string, connectionCode string) (*adapter.ConnectionContext, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	uc, found := s.byUser[username]
+	if !found {
+		return nil, false, nil
+	}
+
+	ctx, found := uc.contexts[connectionCode]
+	return ctx, found, nil
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) GetByInstanceCode(instanceCode string) (*adapter.ConnectionContext, bool, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	ctx, found := s.byInstance[instanceCode]
+	return ctx, found, nil
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) Put(ctx *adapter.ConnectionContext) error {
+	s.Lock()
+	defer s.Unlock()
+
+	uc, found := s.byUser[ctx.Username]
+	if !found {
+		uc = NewUserConnections()
+		s.byUser[ctx.Username] = uc
+	}
+
+	//--- A reconnect can hand the same connection code a new instance code
+	//--- (the adapter opened a fresh broker session); the old one must be
+	//--- evicted here, or it stays reachable through GetByInstanceCode and
+	//--- a delayed webhook can resolve to this superseded context.
+
+	if old, found := uc.contexts[ctx.ConnectionCode]; found && old.InstanceCode != "" && old.InstanceCode != ctx.InstanceCode {
+		delete(s.byInstance, old.InstanceCode)
+	}
+
+	uc.contexts[ctx.ConnectionCode] = ctx
+
+	if ctx.InstanceCode != "" {
+		s.byInstance[ctx.InstanceCode] = ctx
+	}
+
+	return nil
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) Delete(username string, connectionCode string) error {
+	s.Lock()
+	defer s.Unlock()
+
+	uc, found := s.byUser[username]
+	if !found {
+		return req.NewNotFoundError("Connection not found for user: %v", username)
+	}
+
+	ctx, found := uc.contexts[connectionCode]
+	if !found {
+		return req.NewNotFoundError("Connection not found: %v", connectionCode)
+	}
+
+	delete(uc.contexts, connectionCode)
+
+	if ctx.InstanceCode != "" {
+		delete(s.byInstance, ctx.InstanceCode)
+	}
+
+	return nil
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) List(username string) ([]*adapter.ConnectionContext, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	uc, found := s.byUser[username]
+	if !found {
+		return nil, nil
+	}
+
+	var list []*adapter.ConnectionContext
+
+	for _, ctx := range uc.contexts {
+		list = append(list, ctx)
+	}
+
+	return list, nil
+}
+
+//=============================================================================
+
+//--- ListAll returns every connection this store holds, across all users --
+//--- used by RefreshScheduler to report connectionsByStatus over the full
+//--- population rather than just the subset due for a token refresh.
+
+func (s *MemoryConnectionStore) ListAll() ([]*adapter.ConnectionContext, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var list []*adapter.ConnectionContext
+
+	for _, uc := range s.byUser {
+		for _, ctx := range uc.contexts {
+			list = append(list, ctx)
+		}
+	}
+
+	return list, nil
+}
+
+//=============================================================================
+
+func (s *MemoryConnectionStore) ListForRefresh() ([]*adapter.ConnectionContext, error) {
+	s.RLock()
+	defer s.RUnlock()
+
+	var list []*adapter.ConnectionContext
+
+	for _, uc := range s.byUser {
+		for _, ctx := range uc.contexts {
+			if ctx.NeedsRefresh() {
+				list = append(list, ctx)
+			}
+		}
+	}
+
+	return list, nil
+}
+
+//=============================================================================
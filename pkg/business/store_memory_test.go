@@ -0,0 +1,131 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"testing"
+
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+func TestMemoryConnectionStorePutGetRoundTrip(t *testing.T) {
+	s := NewMemoryConnectionStore()
+
+	ctx := &adapter.ConnectionContext{
+		Username      : "alice",
+		ConnectionCode: "conn1",
+		InstanceCode  : "inst1",
+	}
+
+	if err := s.Put(ctx); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	got, found, err := s.Get("alice", "conn1")
+	if err != nil || !found {
+		t.Fatalf("expected Get to find the connection, found=%v err=%v", found, err)
+	}
+	if got.InstanceCode != "inst1" {
+		t.Fatalf("expected InstanceCode inst1, got %v", got.InstanceCode)
+	}
+
+	got, found, err = s.GetByInstanceCode("inst1")
+	if err != nil || !found {
+		t.Fatalf("expected GetByInstanceCode to find the connection, found=%v err=%v", found, err)
+	}
+	if got.ConnectionCode != "conn1" {
+		t.Fatalf("expected ConnectionCode conn1, got %v", got.ConnectionCode)
+	}
+}
+
+//=============================================================================
+
+func TestMemoryConnectionStorePutEvictsStaleInstanceCode(t *testing.T) {
+	s := NewMemoryConnectionStore()
+
+	ctx := &adapter.ConnectionContext{
+		Username      : "alice",
+		ConnectionCode: "conn1",
+		InstanceCode  : "inst1",
+	}
+
+	if err := s.Put(ctx); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	reconnected := &adapter.ConnectionContext{
+		Username      : "alice",
+		ConnectionCode: "conn1",
+		InstanceCode  : "inst2",
+	}
+
+	if err := s.Put(reconnected); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if _, found, _ := s.GetByInstanceCode("inst1"); found {
+		t.Fatal("expected the old instance code to be evicted after reconnect")
+	}
+
+	got, found, err := s.GetByInstanceCode("inst2")
+	if err != nil || !found {
+		t.Fatalf("expected the new instance code to resolve, found=%v err=%v", found, err)
+	}
+	if got != reconnected {
+		t.Fatal("expected GetByInstanceCode(inst2) to return the reconnected context")
+	}
+}
+
+//=============================================================================
+
+func TestMemoryConnectionStoreDeleteRemovesInstanceCode(t *testing.T) {
+	s := NewMemoryConnectionStore()
+
+	ctx := &adapter.ConnectionContext{
+		Username      : "alice",
+		ConnectionCode: "conn1",
+		InstanceCode  : "inst1",
+	}
+
+	if err := s.Put(ctx); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+
+	if err := s.Delete("alice", "conn1"); err != nil {
+		t.Fatalf("Delete failed: %v", err)
+	}
+
+	if _, found, _ := s.Get("alice", "conn1"); found {
+		t.Fatal("expected the connection to be gone after Delete")
+	}
+
+	if _, found, _ := s.GetByInstanceCode("inst1"); found {
+		t.Fatal("expected the instance code to be gone after Delete")
+	}
+}
+
+//=============================================================================
@@ -0,0 +1,224 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/tradalia/core/db"
+	"github.com/tradalia/core/req"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- connectionRow is the XORM model backing the "system_connection" table.
+//--- The adapter-specific state is kept opaque (a single JSON blob) so that
+//--- this store never needs to know about a particular broker's fields.
+
+type connectionRow struct {
+	Username       string `xorm:"pk varchar(100)"`
+	ConnectionCode string `xorm:"pk varchar(100)"`
+	InstanceCode   string `xorm:"index varchar(100)"`
+	SystemCode     string `xorm:"varchar(100)"`
+	State          string `xorm:"text"`
+	UpdatedAt      time.Time `xorm:"updated"`
+}
+
+//=============================================================================
+
+func (connectionRow) TableName() string {
+	return "system_connection"
+}
+
+//=============================================================================
+
+//--- SqlConnectionStore is a durable ConnectionStore backed by the same XORM
+//--- engine used by the rest of tradalia (see Gitea's models package for the
+//--- pattern this follows). It is safe to share across several system-adapter
+//--- replicas since every read/write goes straight to the database.
+
+type SqlConnectionStore struct {
+	resolve func(systemCode string) (adapter.Adapter, bool)
+}
+
+//=============================================================================
+
+func NewSqlConnectionStore(resolve func(systemCode string) (adapter.Adapter, bool)) *SqlConnectionStore {
+	return &SqlConnectionStore{resolve: resolve}
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) Get(username string, connectionCode string) (*adapter.ConnectionContext, bool, error) {
+	var row connectionRow
+
+	found, err := db.GetEngine().Where("username = ? AND connection_code = ?", username, connectionCode).Get(&row)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	ctx, err := s.rehydrate(&row)
+	return ctx, true, err
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) GetByInstanceCode(instanceCode string) (*adapter.ConnectionContext, bool, error) {
+	var row connectionRow
+
+	found, err := db.GetEngine().Where("instance_code = ?", instanceCode).Get(&row)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	ctx, err := s.rehydrate(&row)
+	return ctx, true, err
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) Put(ctx *adapter.ConnectionContext) error {
+	data, err := json.Marshal(ctx.SaveState())
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
+
+	row := connectionRow{
+		Username      : ctx.Username,
+		ConnectionCode: ctx.ConnectionCode,
+		InstanceCode  : ctx.InstanceCode,
+		SystemCode    : ctx.GetAdapterInfo().Code,
+		State         : string(data),
+	}
+
+	c, err := db.GetEngine().Where("username = ? AND connection_code = ?", row.Username, row.ConnectionCode).Update(&row)
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
+
+	if c == 0 {
+		_, err = db.GetEngine().Insert(&row)
+	}
+
+	return err
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) Delete(username string, connectionCode string) error {
+	_, err := db.GetEngine().Where("username = ? AND connection_code = ?", username, connectionCode).Delete(&connectionRow{})
+	return err
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) List(username string) ([]*adapter.ConnectionContext, error) {
+	var rows []connectionRow
+
+	err := db.GetEngine().Where("username = ?", username).Find(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.rehydrateAll(rows)
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) ListAll() ([]*adapter.ConnectionContext, error) {
+	var rows []connectionRow
+
+	err := db.GetEngine().Find(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	return s.rehydrateAll(rows)
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) ListForRefresh() ([]*adapter.ConnectionContext, error) {
+	var rows []connectionRow
+
+	err := db.GetEngine().Find(&rows)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := s.rehydrateAll(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	var toRefresh []*adapter.ConnectionContext
+
+	for _, ctx := range list {
+		if ctx.NeedsRefresh() {
+			toRefresh = append(toRefresh, ctx)
+		}
+	}
+
+	return toRefresh, nil
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) rehydrateAll(rows []connectionRow) ([]*adapter.ConnectionContext, error) {
+	var list []*adapter.ConnectionContext
+
+	for i := range rows {
+		ctx, err := s.rehydrate(&rows[i])
+		if err != nil {
+			return nil, err
+		}
+
+		list = append(list, ctx)
+	}
+
+	return list, nil
+}
+
+//=============================================================================
+
+func (s *SqlConnectionStore) rehydrate(row *connectionRow) (*adapter.ConnectionContext, error) {
+	ad, ok := s.resolve(row.SystemCode)
+	if !ok {
+		return nil, req.NewNotFoundError("System not found: %v", row.SystemCode)
+	}
+
+	var state adapter.State
+
+	err := json.Unmarshal([]byte(row.State), &state)
+	if err != nil {
+		return nil, req.NewServerErrorByError(err)
+	}
+
+	return adapter.RestoreConnectionContext(&state, "", ad)
+}
+
+//=============================================================================
@@ -0,0 +1,203 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"sync"
+
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+type subscriptionKey struct {
+	Username       string
+	ConnectionCode string
+	Symbol         string
+	Channel        string
+}
+
+//=============================================================================
+
+//--- upstream is the single, reference-counted stream opened on the broker
+//--- for a given (username, connectionCode, symbol, channel). Every subscriber fans out
+//--- from the same upstream channel instead of opening one per caller.
+
+type upstream struct {
+	cancel      adapter.CancelFunc
+	subscribers map[chan *adapter.Event]bool
+	mu          sync.Mutex
+}
+
+//=============================================================================
+
+//--- SubscriptionManager multiplexes per-user streaming subscriptions on top
+//--- of ConnectionContext.Subscribe, so that N callers watching the same
+//--- symbol/channel on the same connection share a single upstream stream.
+
+type SubscriptionManager struct {
+	mu   sync.Mutex
+	subs map[subscriptionKey]*upstream
+}
+
+//=============================================================================
+
+func NewSubscriptionManager() *SubscriptionManager {
+	return &SubscriptionManager{
+		subs: make(map[subscriptionKey]*upstream),
+	}
+}
+
+//=============================================================================
+
+var subscriptions = NewSubscriptionManager()
+
+//=============================================================================
+
+//--- Subscribe returns a channel the caller can range over for events on
+//--- symbol/channel for this connection, and a CancelFunc to stop watching.
+//--- The underlying broker stream is only opened once per (username,
+//--- connectionCode, symbol, channel) and shared across every caller watching it.
+
+func (m *SubscriptionManager) Subscribe(ctx *adapter.ConnectionContext, symbol string, channel string) (<-chan *adapter.Event, adapter.CancelFunc, error) {
+	key := subscriptionKey{Username: ctx.Username, ConnectionCode: ctx.ConnectionCode, Symbol: symbol, Channel: channel}
+
+	m.mu.Lock()
+	up, found := m.subs[key]
+	if !found {
+		events, cancel, err := ctx.Subscribe(symbol, channel)
+		if err != nil {
+			m.mu.Unlock()
+			return nil, nil, err
+		}
+
+		up = &upstream{cancel: cancel, subscribers: make(map[chan *adapter.Event]bool)}
+		m.subs[key] = up
+
+		go m.pump(key, up, events)
+	}
+	m.mu.Unlock()
+
+	out := make(chan *adapter.Event, 32)
+
+	up.mu.Lock()
+	up.subscribers[out] = true
+	up.mu.Unlock()
+
+	unsubscribe := func() {
+		m.unsubscribe(key, out)
+	}
+
+	return out, unsubscribe, nil
+}
+
+//=============================================================================
+
+//--- pump fans events from the single upstream broker stream out to every
+//--- subscriber, and tears the upstream down once the broker closes it (e.g.
+//--- the underlying connection dropped).
+
+func (m *SubscriptionManager) pump(key subscriptionKey, up *upstream, events <-chan adapter.Event) {
+	for event := range events {
+		e := event
+
+		up.mu.Lock()
+		for out := range up.subscribers {
+			select {
+				case out <- &e:
+				default:
+					//--- a slow subscriber must not block the others
+			}
+		}
+		up.mu.Unlock()
+	}
+
+	up.mu.Lock()
+	for out := range up.subscribers {
+		close(out)
+	}
+	up.mu.Unlock()
+
+	m.mu.Lock()
+	delete(m.subs, key)
+	m.mu.Unlock()
+}
+
+//=============================================================================
+
+func (m *SubscriptionManager) unsubscribe(key subscriptionKey, out chan *adapter.Event) {
+	m.mu.Lock()
+	up, found := m.subs[key]
+	m.mu.Unlock()
+
+	if !found {
+		return
+	}
+
+	up.mu.Lock()
+	delete(up.subscribers, out)
+	remaining := len(up.subscribers)
+	up.mu.Unlock()
+
+	if remaining == 0 {
+		m.mu.Lock()
+		delete(m.subs, key)
+		m.mu.Unlock()
+
+		up.cancel()
+	}
+}
+
+//=============================================================================
+
+//--- DropConnection cancels every subscription open for connectionCode. It
+//--- is called when the underlying connection changes status to something
+//--- other than connected, so that subscribers stop receiving stale events
+//--- and can resubscribe once the connection comes back.
+
+func (m *SubscriptionManager) DropConnection(connectionCode string) {
+	m.mu.Lock()
+	var dropped []subscriptionKey
+
+	for key := range m.subs {
+		if key.ConnectionCode == connectionCode {
+			dropped = append(dropped, key)
+		}
+	}
+	m.mu.Unlock()
+
+	for _, key := range dropped {
+		m.mu.Lock()
+		up, found := m.subs[key]
+		delete(m.subs, key)
+		m.mu.Unlock()
+
+		if found {
+			up.cancel()
+		}
+	}
+}
+
+//=============================================================================
@@ -0,0 +1,158 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/tradalia/core/auth"
+	"github.com/tradalia/core/req"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+const (
+	streamHeartbeat = 15 * time.Second
+)
+
+//=============================================================================
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize : 1024,
+	WriteBufferSize: 1024,
+}
+
+//=============================================================================
+
+//--- SubscribeStream fans out streaming events for (connectionCode, symbol,
+//--- channel) to the caller: a WebSocket upgrade is attempted first, falling
+//--- back to Server-Sent Events when the client doesn't ask for an upgrade.
+//--- The caller is expected to resubscribe (call this handler again) if its
+//--- stream closes, which is what happens whenever the underlying connection
+//--- drops -- see publishConnectionChange/SubscriptionManager.DropConnection.
+
+func SubscribeStream(c *auth.Context, connectionCode string, symbol string, channel string) error {
+	if err := requireScope(c, connectionCode, "SubscribeStream", ScopeMarketDataRead); err != nil {
+		return err
+	}
+
+	ctx, err := getConnectionContext(c, connectionCode)
+	if err != nil {
+		return err
+	}
+
+	events, cancel, err := subscriptions.Subscribe(ctx, symbol, channel)
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
+	defer cancel()
+
+	w := c.Gin.Writer
+	r := c.Gin.Request
+
+	if websocket.IsWebSocketUpgrade(r) {
+		return streamOverWebSocket(w, r, events)
+	}
+
+	return streamOverSSE(w, events)
+}
+
+//=============================================================================
+
+func streamOverWebSocket(w http.ResponseWriter, r *http.Request, events <-chan *adapter.Event) error {
+	conn, err := upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return req.NewServerErrorByError(err)
+	}
+	defer conn.Close()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case event, open := <-events:
+				if !open {
+					return nil
+				}
+
+				err := conn.WriteJSON(event)
+				if err != nil {
+					return req.NewServerErrorByError(err)
+				}
+
+			case <-ticker.C:
+				err := conn.WriteMessage(websocket.PingMessage, nil)
+				if err != nil {
+					return req.NewServerErrorByError(err)
+				}
+		}
+	}
+}
+
+//=============================================================================
+
+func streamOverSSE(w http.ResponseWriter, events <-chan *adapter.Event) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		return req.NewServerError("Streaming unsupported")
+	}
+
+	h := w.Header()
+	h.Set("Content-Type", "text/event-stream")
+	h.Set("Cache-Control", "no-cache")
+	h.Set("Connection", "keep-alive")
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+
+	for {
+		select {
+			case event, open := <-events:
+				if !open {
+					return nil
+				}
+
+				data, err := json.Marshal(event)
+				if err != nil {
+					return req.NewServerErrorByError(err)
+				}
+
+				fmt.Fprintf(w, "data: %s\n\n", data)
+				flusher.Flush()
+
+			case <-ticker.C:
+				fmt.Fprintf(w, ": ping\n\n")
+				flusher.Flush()
+		}
+	}
+}
+
+//=============================================================================
@@ -0,0 +1,62 @@
+//=============================================================================
+/*
+Copyright © 2023 Andrea Carboni andrea.carboni71@gmail.com
+
+Permission is hereby granted, free of charge, to any person obtaining a copy
+of this software and associated documentation files (the "Software"), to deal
+in the Software without restriction, including without limitation the rights
+to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+copies of the Software, and to permit persons to whom the Software is
+furnished to do so, subject to the following conditions:
+
+The above copyright notice and this permission notice shall be included in
+all copies or substantial portions of the Software.
+
+THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+THE SOFTWARE.
+*/
+//=============================================================================
+
+package business
+
+import (
+	"github.com/tradalia/core/req"
+	"github.com/tradalia/system-adapter/pkg/adapter"
+)
+
+//=============================================================================
+
+//--- webhookBaseUrl is the externally reachable base URL broker callbacks
+//--- (OAuth redirects, order fills, ...) are pointed at. It defaults to the
+//--- historical hardcoded host so existing deployments keep working until
+//--- they call SetWebhookBaseUrl from their own config.
+
+var webhookBaseUrl = "https://tradalia-server:8449"
+
+//=============================================================================
+
+func SetWebhookBaseUrl(url string) {
+	webhookBaseUrl = url
+}
+
+//=============================================================================
+
+//--- HandleInstanceCallback resolves the ConnectionContext a broker webhook
+//--- or OAuth redirect is targeting from its instance code alone, since
+//--- those callbacks have no user session to key off of.
+
+func HandleInstanceCallback(instanceCode string) (*adapter.ConnectionContext, error) {
+	ctx := GetConnectionContextByInstanceCode(instanceCode)
+	if ctx == nil {
+		return nil, req.NewNotFoundError("Connection not found for instance: %v", instanceCode)
+	}
+
+	return ctx, nil
+}
+
+//=============================================================================